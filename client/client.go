@@ -0,0 +1,164 @@
+// Package client is a small helper for driving the resumable upload
+// endpoints (POST /uploads, HEAD/PATCH/DELETE /uploads/{id}) from other
+// Go programs, handling offset discovery and chunked PATCH so callers
+// don't have to.
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// Upload describes an in-progress resumable upload as returned by the
+// server's create/HEAD endpoints.
+type Upload struct {
+	ID       string `json:"id"`
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+	Offset   int64  `json:"offset"`
+}
+
+// ChunkSize is the default size of each PATCH sent by UploadFile.
+const ChunkSize = 4 * 1024 * 1024 // 4MB
+
+// Client talks to a zerpa server's resumable upload endpoints.
+type Client struct {
+	BaseURL string
+	HTTP    *http.Client
+	// Token, if set, is sent as an X-Auth-Token header on every request,
+	// for servers configured with an Authenticator.
+	Token string
+}
+
+// New returns a Client pointed at baseURL (e.g. "http://localhost:8080").
+func New(baseURL string) *Client {
+	return &Client{BaseURL: baseURL, HTTP: http.DefaultClient}
+}
+
+// newRequest builds an HTTP request against the upload endpoints, adding
+// the X-Auth-Token header when the Client has a Token configured.
+func (c *Client) newRequest(method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.Token != "" {
+		req.Header.Set("X-Auth-Token", c.Token)
+	}
+	return req, nil
+}
+
+// CreateUpload registers a new upload of the given filename and size and
+// returns its ID.
+func (c *Client) CreateUpload(filename string, size int64) (string, error) {
+	body, _ := json.Marshal(map[string]interface{}{"filename": filename, "size": size})
+	req, err := c.newRequest(http.MethodPost, c.BaseURL+"/uploads", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("create upload: unexpected status %s", resp.Status)
+	}
+	var u Upload
+	if err := json.NewDecoder(resp.Body).Decode(&u); err != nil {
+		return "", err
+	}
+	return u.ID, nil
+}
+
+// Offset asks the server how many bytes of upload id it has received so
+// far, so a client can resume after a dropped connection.
+func (c *Client) Offset(id string) (int64, error) {
+	req, err := c.newRequest(http.MethodHead, c.BaseURL+"/uploads/"+id, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("offset: unexpected status %s", resp.Status)
+	}
+	return strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+}
+
+// CancelUpload abandons an in-progress upload, so the server releases
+// whatever quota it reserved for it instead of holding that reservation
+// until the upload is eventually finished.
+func (c *Client) CancelUpload(id string) error {
+	req, err := c.newRequest(http.MethodDelete, c.BaseURL+"/uploads/"+id, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("cancel upload: unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// UploadFile resumes (or starts) sending the contents of path in
+// ChunkSize-sized PATCH requests, starting from whatever offset the
+// server reports it already has.
+func (c *Client) UploadFile(id, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	offset, err := c.Offset(id)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	buf := make([]byte, ChunkSize)
+	for offset < info.Size() {
+		n, err := f.Read(buf)
+		if n == 0 && err != nil {
+			return err
+		}
+
+		req, err := c.newRequest(http.MethodPatch, c.BaseURL+"/uploads/"+id, bytes.NewReader(buf[:n]))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+int64(n)-1, info.Size()))
+
+		resp, err := c.HTTP.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			return fmt.Errorf("patch upload: unexpected status %s", resp.Status)
+		}
+		offset += int64(n)
+	}
+	return nil
+}
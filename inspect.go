@@ -0,0 +1,142 @@
+// inspect.go
+// Inspectors that run inline as bytes stream from a multipart part to
+// the storage backend: a MIME allowlist sniffer, a size/quota limiter,
+// and a SHA-256 hasher. Each is a thin io.Reader wrapper, so storeFilePart
+// can chain them without taking a second pass over the data.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+)
+
+// sniffSize is how many leading bytes are buffered before
+// http.DetectContentType can make a reliable call.
+const sniffSize = 512
+
+// errRejectedMIME is returned once the sniffed content type doesn't
+// match the configured allowlist.
+type errRejectedMIME struct{ mimeType string }
+
+func (e errRejectedMIME) Error() string {
+	return fmt.Sprintf("content type %q is not allowed", e.mimeType)
+}
+
+// sniffReader buffers the first sniffSize bytes of the wrapped reader,
+// checks them against an allowlist before releasing any bytes to the
+// caller, and then streams the rest through unmodified. An empty
+// allowlist disables the check.
+type sniffReader struct {
+	r         io.Reader
+	allowlist []string
+	buf       bytes.Buffer
+	checked   bool
+	err       error
+}
+
+func newSniffReader(r io.Reader, allowlist []string) *sniffReader {
+	return &sniffReader{r: r, allowlist: allowlist}
+}
+
+func (s *sniffReader) Read(p []byte) (int, error) {
+	if !s.checked {
+		if err := s.fill(); err != nil {
+			return 0, err
+		}
+	}
+	if s.buf.Len() > 0 {
+		return s.buf.Read(p)
+	}
+	return s.r.Read(p)
+}
+
+// fill reads up to sniffSize bytes into s.buf and validates them.
+func (s *sniffReader) fill() error {
+	if _, err := io.CopyN(&s.buf, s.r, sniffSize); err != nil && err != io.EOF {
+		return err
+	}
+	s.checked = true
+
+	if len(s.allowlist) == 0 {
+		return nil
+	}
+	contentType := http.DetectContentType(s.buf.Bytes())
+	for _, allowed := range s.allowlist {
+		if contentType == allowed {
+			return nil
+		}
+	}
+	return errRejectedMIME{mimeType: contentType}
+}
+
+// errQuotaExceeded is returned once a quotaReader's caller has read more
+// bytes than the configured limit.
+var errQuotaExceeded = fmt.Errorf("upload exceeds the allowed size or quota")
+
+// quotaReader caps how many bytes may be read from r, so storeFilePart
+// can abort and unlink a file that blows past a max-size or per-user
+// disk quota mid-stream. A stream whose length exactly equals limit
+// reads through to a clean io.EOF, same as io.LimitReader; only a
+// stream that actually has more than limit bytes errors.
+type quotaReader struct {
+	r      io.Reader
+	remain int64
+	err    error // sticky, once the quota has been exceeded
+}
+
+func newQuotaReader(r io.Reader, limit int64) *quotaReader {
+	return &quotaReader{r: r, remain: limit}
+}
+
+func (q *quotaReader) Read(p []byte) (int, error) {
+	if q.err != nil {
+		return 0, q.err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	// Ask for one byte more than remains, so a stream that ends exactly
+	// at the limit is distinguishable from one that overruns it: the
+	// former yields n <= remain, the latter n == remain+1. Skip this
+	// when remain is the unlimited sentinel, since remain+1 would
+	// overflow and truncate p to a negative length.
+	if q.remain < unlimitedBytes && int64(len(p)) > q.remain+1 {
+		p = p[:q.remain+1]
+	}
+	n, err := q.r.Read(p)
+
+	if int64(n) <= q.remain {
+		q.remain -= int64(n)
+		return n, err
+	}
+
+	allowed := int(q.remain)
+	q.remain = 0
+	q.err = errQuotaExceeded
+	return allowed, q.err
+}
+
+// hashReader accumulates a SHA-256 digest of every byte read, so the
+// digest is available once the stream has been fully copied without a
+// second pass over the data.
+type hashReader struct {
+	r io.Reader
+	h hash.Hash
+}
+
+func newHashReader(r io.Reader, h hash.Hash) *hashReader {
+	return &hashReader{r: r, h: h}
+}
+
+func (h *hashReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	if n > 0 {
+		h.h.Write(p[:n])
+	}
+	return n, err
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestQuotaReaderExactBoundary(t *testing.T) {
+	data := bytes.Repeat([]byte("a"), 1000)
+
+	n, err := io.Copy(io.Discard, newQuotaReader(bytes.NewReader(data), 1000))
+	if err != nil {
+		t.Fatalf("a stream exactly at the limit should read through cleanly, got n=%d err=%v", n, err)
+	}
+
+	over := bytes.Repeat([]byte("a"), 1001)
+	_, err = io.Copy(io.Discard, newQuotaReader(bytes.NewReader(over), 1000))
+	if err != errQuotaExceeded {
+		t.Fatalf("a stream one byte over the limit should fail with errQuotaExceeded, got %v", err)
+	}
+
+	_, err = io.Copy(io.Discard, newQuotaReader(bytes.NewReader(data), unlimitedBytes))
+	if err != nil {
+		t.Fatalf("an unlimited quotaReader should never reject, got %v", err)
+	}
+}
+
+func TestSniffReaderAllowlist(t *testing.T) {
+	png := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 100)...)
+
+	_, err := io.ReadAll(newSniffReader(bytes.NewReader(png), []string{"image/png"}))
+	if err != nil {
+		t.Fatalf("an allowed MIME type should pass through, got %v", err)
+	}
+
+	_, err = io.ReadAll(newSniffReader(bytes.NewReader([]byte("plain text content")), []string{"image/png"}))
+	var mimeErr errRejectedMIME
+	if err == nil {
+		t.Fatal("a disallowed MIME type should be rejected")
+	} else if !errors.As(err, &mimeErr) {
+		t.Fatalf("expected an errRejectedMIME, got %v (%T)", err, err)
+	}
+
+	_, err = io.ReadAll(newSniffReader(bytes.NewReader([]byte("plain text content")), nil))
+	if err != nil {
+		t.Fatalf("an empty allowlist should disable the check, got %v", err)
+	}
+}
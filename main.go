@@ -5,99 +5,366 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
+	"mime/multipart"
 	"net/http"
-	"os"
+	"path"
 	"path/filepath"
+	"strings"
 )
 
 const (
 	uploadPath = "./uploaded"
 	staticPath = "./static"
-	maxMemory  = 1024 * 1024 * 512 // 512MB for parsing form (file is streamed to disk)
+	// checksumDir namespaces generated .sha256 sidecars away from user
+	// files, so a user uploading a file that happens to end in ".sha256"
+	// can't collide with (or be hidden by) one of our own sidecars.
+	checksumDir = ".checksums"
 )
 
+// store is the active Storage backend, selected once at startup by
+// newStorageFromEnv and shared by every handler.
+var store Storage
+
+// authenticator guards /upload when configured; nil means uploads are
+// unauthenticated and unnamespaced, preserving zerpa's original behavior.
+var authenticator Authenticator
+
+// limits caps single-file size, per-user disk quota, and acceptable MIME
+// types for uploads.
+var limits uploadLimits
+
+// scanner, when configured, inspects every uploaded file once it has
+// been written to storage and rejects it if the scanner objects.
+var scanner Scanner
+
 func main() {
-	// Ensure upload directory exists
-	err := os.MkdirAll(uploadPath, os.ModePerm)
+	var err error
+	store, err = newStorageFromEnv()
+	if err != nil {
+		panic("Could not initialize storage backend: " + err.Error())
+	}
+	authenticator, err = newAuthenticatorFromEnv()
+	if err != nil {
+		panic("Could not initialize authenticator: " + err.Error())
+	}
+	limits = newUploadLimitsFromEnv()
+	scanner, err = newScannerFromEnv()
 	if err != nil {
-		panic("Could not create upload directory: " + err.Error())
+		panic("Could not initialize scanner: " + err.Error())
 	}
 
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir(staticPath))))
-	http.Handle("/uploaded/", http.StripPrefix("/uploaded/", http.FileServer(http.Dir(uploadPath))))
+	http.HandleFunc("/uploaded/", servedFileHandler)
 	http.HandleFunc("/", uploadPage)
+	http.HandleFunc("/login", loginHandler)
 	http.HandleFunc("/upload", uploadHandler)
+	http.HandleFunc("/uploads", createUploadHandler)
+	http.HandleFunc("/uploads/", resumableUploadHandler)
 
 	fmt.Println("Server running on http://localhost:8080")
 	http.ListenAndServe(":8080", nil)
 }
 
+// uploadPageData is the template context for htmlPage: the caller's
+// files (scoped to their own namespace when auth is configured), their
+// username, and a fresh CSRF token for the upload form.
+type uploadPageData struct {
+	Files       []FileInfo
+	User        string
+	CSRF        string
+	AuthEnabled bool
+}
+
 func uploadPage(w http.ResponseWriter, r *http.Request) {
-	files, err := listUploadedFiles()
+	var prefix, user, csrf string
+	if authenticator != nil {
+		if s := sessionFromRequest(r); s != nil {
+			user, csrf = s.user, s.csrf
+			prefix = user + "/"
+		}
+	}
+
+	allFiles, err := store.List(prefix)
 	if err != nil {
 		http.Error(w, "Cannot list files: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	files := make([]FileInfo, 0, len(allFiles))
+	for _, f := range allFiles {
+		if !strings.HasPrefix(f.Name, checksumDir+"/") {
+			files = append(files, f)
+		}
+	}
 
 	tmpl := template.Must(template.New("upload").Parse(htmlPage))
-	tmpl.Execute(w, files)
+	tmpl.Execute(w, uploadPageData{
+		Files:       files,
+		User:        user,
+		CSRF:        csrf,
+		AuthEnabled: authenticator != nil,
+	})
 }
 
+// servedFileHandler serves /uploaded/<name> through the active Storage
+// backend, so files can be fetched the same way regardless of whether
+// they live on local disk, in S3, or in GCS. When auth is configured,
+// callers may only fetch files under their own namespace.
+func servedFileHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/uploaded/")
+
+	if authenticator != nil {
+		s := sessionFromRequest(r)
+		if s == nil || !strings.HasPrefix(name, s.user+"/") {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
+	f, err := store.Open(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	io.Copy(w, f)
+}
+
+// uploadManifest is the JSON response returned once every part of a
+// multipart upload has been streamed to disk.
+type uploadManifest struct {
+	Files  []storedFile      `json:"files"`
+	Fields map[string]string `json:"fields"`
+}
+
+type storedFile struct {
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256"`
+}
+
+// uploadHandler streams each part of a multipart/form-data request
+// directly to disk via r.MultipartReader(), so uploads far larger than
+// any fixed in-memory buffer don't exhaust memory or temp disk space.
+// File parts are written as they arrive; non-file parts are collected
+// into a field map.
+//
+// When authenticator is configured, credentials travel as the leading
+// parts of this same multipart stream (or, for a urlAuthenticator, as
+// query parameters checked before the stream is even opened) and are
+// validated before the first file part's body is read, so an
+// unauthorized caller never gets to transfer file bytes. Authenticated
+// files are namespaced under the caller's username.
 func uploadHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "POST only", http.StatusMethodNotAllowed)
 		return
 	}
 
-	err := r.ParseMultipartForm(maxMemory)
-	if err != nil {
-		http.Error(w, "Could not parse multipart form: "+err.Error(), http.StatusBadRequest)
-		return
+	var user string
+	authenticated := authenticator == nil
+
+	if !authenticated {
+		if ua, ok := authenticator.(urlAuthenticator); ok {
+			u, err := ua.AuthenticateURL(r.URL.Query())
+			if err != nil {
+				http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+				return
+			}
+			user, authenticated = u, true
+		}
 	}
-	file, handler, err := r.FormFile("file")
+
+	mr, err := r.MultipartReader()
 	if err != nil {
-		http.Error(w, "Could not get uploaded file: "+err.Error(), http.StatusBadRequest)
+		http.Error(w, "Could not read multipart request: "+err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer file.Close()
 
-	dstPath := filepath.Join(uploadPath, filepath.Base(handler.Filename))
-	dst, err := os.Create(dstPath)
-	if err != nil {
-		http.Error(w, "Could not save file: "+err.Error(), http.StatusInternalServerError)
-		return
+	sess := sessionFromRequest(r)
+	var creds Credentials
+	manifest := uploadManifest{Fields: map[string]string{}}
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, "Could not read multipart part: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if part.FileName() == "" {
+			value, err := io.ReadAll(part)
+			formName := part.FormName()
+			part.Close()
+			if err != nil {
+				http.Error(w, "Could not read form field: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			switch formName {
+			case "token":
+				creds.Token = string(value)
+			case "username":
+				creds.Username = string(value)
+			case "password":
+				creds.Password = string(value)
+			case "csrf_token":
+				if !authenticated && sess != nil && validCSRF(sess, string(value)) {
+					user, authenticated = sess.user, true
+				}
+			default:
+				manifest.Fields[formName] = string(value)
+			}
+			continue
+		}
+
+		if !authenticated {
+			u, err := authenticator.Authenticate(creds)
+			if err != nil {
+				part.Close()
+				http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+				return
+			}
+			user, authenticated = u, true
+		}
+
+		stored, err := storeFilePart(part, user)
+		part.Close()
+		if err != nil {
+			status := http.StatusInternalServerError
+			if isClientRejection(err) {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, "Could not save file: "+err.Error(), status)
+			return
+		}
+		manifest.Files = append(manifest.Files, stored)
 	}
-	defer dst.Close()
 
-	_, err = io.Copy(dst, file)
-	if err != nil {
-		http.Error(w, "Could not write file: "+err.Error(), http.StatusInternalServerError)
+	if !authenticated {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	w.Write([]byte("success"))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(manifest)
 }
 
-func listUploadedFiles() ([]string, error) {
-	f, err := os.Open(uploadPath)
+// storeFilePart streams a single file part into the active Storage
+// backend without buffering it in memory. When user is non-empty the
+// file is namespaced under uploadPath/<user>/... so callers only ever
+// see their own files. See storeInspected for the inspector chain this
+// runs the part through.
+func storeFilePart(part *multipart.Part, user string) (storedFile, error) {
+	filename := filepath.Base(part.FileName())
+	return storeInspected(filename, user, part)
+}
+
+// storeInspected pushes src through the same inspector chain and into
+// the active Storage backend under filename (namespaced by user), and
+// underlies storeFilePart's one-shot multipart path. It reserves
+// headroom against the user's quota itself, since a one-shot upload's
+// size isn't known until the bytes are actually counted.
+//
+// As bytes flow from src to the backend they pass through a chain of
+// inspectors in a single read pass: a MIME sniffer, a max-size/quota
+// limiter, and a SHA-256 hasher. A rejection from any of them aborts the
+// write and unlinks whatever was stored so far. Once the backend has the
+// whole file, an optional external scanner gets a chance to reject it
+// too, in which case it's removed rather than left in place.
+func storeInspected(filename, user string, src io.Reader) (storedFile, error) {
+	// reserve briefly locks the user to check and record quota headroom,
+	// so two concurrent uploads from the same user can't both pass the
+	// quota check before either write has landed; it doesn't hold the
+	// lock for the transfer and scan below, which can be slow.
+	remaining, release, err := limits.reserve(user, unlimitedBytes)
 	if err != nil {
-		return nil, err
+		return storedFile{}, err
 	}
-	defer f.Close()
-	fileInfos, err := f.Readdir(-1)
-	if err != nil {
-		return nil, err
+	defer release()
+	return storeInspectedWithLimit(filename, user, src, remaining)
+}
+
+// storeInspectedWithLimit is storeInspected's inspector chain, capped at
+// a caller-supplied limit instead of deriving its own quota reservation.
+// finalizeResumableUpload uses this directly: it already holds a
+// reservation for the upload's declared size from createUploadHandler,
+// and taking a second one here would count those bytes against the
+// user's quota twice.
+func storeInspectedWithLimit(filename, user string, src io.Reader, limit int64) (storedFile, error) {
+	name := filename
+	if user != "" {
+		name = path.Join(user, filename)
+	}
+	checksumName := path.Join(checksumDir, name) + ".sha256"
+
+	src = newSniffReader(src, limits.mimeAllow)
+	src = newQuotaReader(src, limit)
+	hasher := sha256.New()
+	src = newHashReader(src, hasher)
+	counted := &countingReader{r: src}
+
+	if _, err := store.Put(name, counted); err != nil {
+		store.Delete(name)
+		return storedFile{}, err
 	}
-	files := []string{}
-	for _, fi := range fileInfos {
-		if !fi.IsDir() {
-			files = append(files, fi.Name())
+
+	digest := hex.EncodeToString(hasher.Sum(nil))
+	if _, err := store.Put(checksumName, strings.NewReader(digest)); err != nil {
+		store.Delete(name)
+		return storedFile{}, fmt.Errorf("could not persist checksum: %w", err)
+	}
+
+	if scanner != nil {
+		if err := scanFile(name); err != nil {
+			store.Delete(name)
+			store.Delete(checksumName)
+			return storedFile{}, err
 		}
 	}
-	return files, nil
+
+	return storedFile{Filename: filename, Size: counted.n, SHA256: digest}, nil
+}
+
+// isClientRejection reports whether err is one the caller could have
+// avoided (a disallowed MIME type or an exceeded size/quota limit), as
+// opposed to a server-side storage failure.
+func isClientRejection(err error) bool {
+	var mimeErr errRejectedMIME
+	return errors.As(err, &mimeErr) || errors.Is(err, errQuotaExceeded)
+}
+
+// scanFile reopens a just-written file from storage and runs it through
+// the configured Scanner.
+func scanFile(name string) error {
+	f, err := store.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return scanner.Scan(f)
+}
+
+// countingReader tracks how many bytes have passed through it, so
+// storeFilePart can report the stored size without a second read of the
+// backend-stored object.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(buf []byte) (int, error) {
+	n, err := c.r.Read(buf)
+	c.n += int64(n)
+	return n, err
 }
 
 const htmlPage = `
@@ -109,9 +376,20 @@ const htmlPage = `
     <link rel="stylesheet" href="/static/style.css">
 </head>
 <body>
+    {{if .User}}
+    <p>Logged in as {{.User}}</p>
+    {{else if .AuthEnabled}}
+    <form id="loginForm" method="post" action="/login">
+        <input type="text" name="username" placeholder="username" required>
+        <input type="password" name="password" placeholder="password" required>
+        <button type="submit">Log in</button>
+    </form>
+    {{end}}
+
     <h2>Upload a file</h2>
     <form id="uploadForm">
-        <input type="file" name="file" id="fileInput" required>
+        <input type="hidden" name="csrf_token" value="{{.CSRF}}">
+        <input type="file" name="file" id="fileInput" multiple required>
         <button type="submit">Upload</button>
     </form>
     <div id="progressContainer" style="display:none;">
@@ -122,8 +400,8 @@ const htmlPage = `
 
     <h3>Uploaded files</h3>
     <ul>
-    {{range .}}
-        <li><a href="/uploaded/{{.}}" target="_blank">{{.}}</a></li>
+    {{range .Files}}
+        <li><a href="{{.URL}}" target="_blank">{{.Name}}</a></li>
     {{else}}
         <li>No files uploaded yet.</li>
     {{end}}
@@ -0,0 +1,171 @@
+// quota.go
+// Size and per-user disk quota limits for uploads, configured via env
+// vars. Both are optional; zero means unlimited.
+
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// uploadLimits holds the configured max single-file size and per-user
+// total disk quota, both in bytes. Zero means unlimited.
+type uploadLimits struct {
+	maxFileSize int64
+	userQuota   int64
+	mimeAllow   []string
+}
+
+func newUploadLimitsFromEnv() uploadLimits {
+	var limits uploadLimits
+	limits.maxFileSize = parseByteEnv("ZERPA_MAX_FILE_SIZE")
+	limits.userQuota = parseByteEnv("ZERPA_USER_QUOTA")
+	if raw := os.Getenv("ZERPA_MIME_ALLOWLIST"); raw != "" {
+		for _, t := range strings.Split(raw, ",") {
+			limits.mimeAllow = append(limits.mimeAllow, strings.TrimSpace(t))
+		}
+	}
+	return limits
+}
+
+func parseByteEnv(name string) int64 {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return 0
+	}
+	n, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+const unlimitedBytes = 1<<63 - 1
+
+// remainingBytes returns how many more bytes a single Put may write
+// without exceeding the max file size or, when user is non-empty, that
+// user's remaining disk quota (computed by summing their files already
+// in storage). A limit of 0 is treated as unlimited; a quota that has
+// already been used up correctly yields 0 remaining, not unlimited.
+func (l uploadLimits) remainingBytes(user string) (int64, error) {
+	limit := int64(unlimitedBytes)
+	if l.maxFileSize > 0 {
+		limit = l.maxFileSize
+	}
+
+	if l.userQuota > 0 && user != "" {
+		used, err := quotaUsed(user)
+		if err != nil {
+			return 0, err
+		}
+		remaining := l.userQuota - used
+		if remaining < 0 {
+			remaining = 0
+		}
+		if remaining < limit {
+			limit = remaining
+		}
+	}
+
+	return limit, nil
+}
+
+// userQuotaLocks serializes the brief check-quota-and-reserve step per
+// user, so two concurrent uploads from the same user can't both observe
+// quota headroom that only one of them actually has. It is held only
+// long enough to compute and record a reservation, not for the (much
+// slower) transfer and scan that follow — see reserve.
+var (
+	userQuotaLocksMu sync.Mutex
+	userQuotaLocks   = map[string]*sync.Mutex{}
+)
+
+// lockUser returns a mutex dedicated to user, creating it on first use.
+func lockUser(user string) *sync.Mutex {
+	userQuotaLocksMu.Lock()
+	defer userQuotaLocksMu.Unlock()
+	m, ok := userQuotaLocks[user]
+	if !ok {
+		m = &sync.Mutex{}
+		userQuotaLocks[user] = m
+	}
+	return m
+}
+
+// pending tracks, per user, bytes reserved by uploads that have passed
+// the quota check but not yet finished writing (and so aren't yet
+// reflected in quotaUsed's tally of files actually in storage).
+var (
+	pendingMu sync.Mutex
+	pending   = map[string]int64{}
+)
+
+// reserve computes how many more bytes user may write without exceeding
+// the configured limits — accounting for any other in-flight upload
+// from the same user that has already reserved headroom — and
+// provisionally reserves up to want bytes of that headroom (want may be
+// unlimitedBytes for a caller that doesn't know its size up front, in
+// which case it's granted whatever headroom remains). The per-user lock
+// is held only for this quick check-and-record step; release must be
+// called exactly once (success or failure) once the caller's transfer
+// has finished, to free the reservation.
+func (l uploadLimits) reserve(user string, want int64) (granted int64, release func(), err error) {
+	lock := lockUser(user)
+	lock.Lock()
+	defer lock.Unlock()
+
+	remaining, err := l.remainingBytes(user)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	pendingMu.Lock()
+	if already := pending[user]; already < remaining {
+		remaining -= already
+	} else {
+		remaining = 0
+	}
+	granted = want
+	if granted > remaining {
+		granted = remaining
+	}
+	pending[user] += granted
+	pendingMu.Unlock()
+
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			releasePending(user, granted)
+		})
+	}
+	return granted, release, nil
+}
+
+// releasePending frees amount bytes previously granted to user by
+// reserve, for a caller that can't hold reserve's release closure —
+// e.g. because the reservation outlives a single request, as a
+// resumable upload's does between its create and finalize calls.
+func releasePending(user string, amount int64) {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	pending[user] -= amount
+	if pending[user] <= 0 {
+		delete(pending, user)
+	}
+}
+
+// quotaUsed sums the size of every file a user already has in storage.
+func quotaUsed(user string) (int64, error) {
+	files, err := store.List(user + "/")
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, f := range files {
+		total += f.Size
+	}
+	return total, nil
+}
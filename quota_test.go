@@ -0,0 +1,135 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"testing"
+)
+
+// fakeStorage is a minimal in-memory Storage for exercising quota logic
+// without touching disk; only List is used by these tests.
+type fakeStorage struct {
+	mu    sync.Mutex
+	files map[string]int64 // name -> size
+}
+
+func newFakeStorage(files map[string]int64) *fakeStorage {
+	return &fakeStorage{files: files}
+}
+
+func (s *fakeStorage) Put(name string, r io.Reader) (string, error) {
+	n, err := io.Copy(io.Discard, r)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	s.files[name] = n
+	s.mu.Unlock()
+	return "", nil
+}
+
+func (s *fakeStorage) List(prefix string) ([]FileInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var files []FileInfo
+	for name, size := range s.files {
+		if len(prefix) == 0 || len(name) >= len(prefix) && name[:len(prefix)] == prefix {
+			files = append(files, FileInfo{Name: name, Size: size})
+		}
+	}
+	return files, nil
+}
+
+func (s *fakeStorage) Open(name string) (io.ReadCloser, error) { return nil, nil }
+func (s *fakeStorage) Delete(name string) error                { return nil }
+
+func withStore(t *testing.T, s Storage) {
+	prev := store
+	store = s
+	t.Cleanup(func() { store = prev })
+}
+
+func TestRemainingBytesQuotaExhausted(t *testing.T) {
+	withStore(t, newFakeStorage(map[string]int64{"alice/a.png": 50}))
+
+	limits := uploadLimits{userQuota: 50}
+	remaining, err := limits.remainingBytes("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remaining != 0 {
+		t.Fatalf("got remaining=%d, want 0 once the quota is fully used", remaining)
+	}
+}
+
+func TestRemainingBytesCombinesFileSizeAndQuota(t *testing.T) {
+	withStore(t, newFakeStorage(map[string]int64{"alice/a.png": 10}))
+
+	limits := uploadLimits{maxFileSize: 1000, userQuota: 50}
+	remaining, err := limits.remainingBytes("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remaining != 40 {
+		t.Fatalf("got remaining=%d, want 40 (quota headroom, tighter than maxFileSize)", remaining)
+	}
+}
+
+func TestReserveAccountsForInFlightUpload(t *testing.T) {
+	withStore(t, newFakeStorage(map[string]int64{}))
+	limits := uploadLimits{userQuota: 100}
+
+	remaining1, release1, err := limits.reserve("alice", unlimitedBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remaining1 != 100 {
+		t.Fatalf("first reserve: got %d, want 100", remaining1)
+	}
+
+	// A second concurrent reserve, before the first upload has landed in
+	// storage, must not be handed the same 100 bytes of headroom again.
+	remaining2, release2, err := limits.reserve("alice", unlimitedBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remaining2 != 0 {
+		t.Fatalf("second concurrent reserve: got %d, want 0 (already reserved by the first)", remaining2)
+	}
+
+	release1()
+	release2()
+
+	remaining3, release3, err := limits.reserve("alice", unlimitedBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remaining3 != 100 {
+		t.Fatalf("after both releases: got %d, want 100 again", remaining3)
+	}
+	release3()
+}
+
+func TestReserveCapsAtWant(t *testing.T) {
+	withStore(t, newFakeStorage(map[string]int64{}))
+	limits := uploadLimits{userQuota: 100}
+
+	granted, release, err := limits.reserve("alice", 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if granted != 30 {
+		t.Fatalf("got granted=%d, want 30 (capped at want, not the full 100 quota)", granted)
+	}
+
+	granted2, release2, err := limits.reserve("alice", unlimitedBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if granted2 != 70 {
+		t.Fatalf("got granted=%d, want 70 (100 quota minus alice's 30 already reserved)", granted2)
+	}
+
+	release()
+	release2()
+}
@@ -0,0 +1,408 @@
+// resumable.go
+// A tus-style resumable upload subsystem, independent of the one-shot
+// multipart path in uploadHandler. Clients create an upload, then PATCH
+// bytes in over one or more requests (resuming after a dropped
+// connection by first asking HEAD for the current offset), and the
+// server finalizes the file once the full size has been received.
+//
+// This is what static/upload.js drives, and it reports progress by
+// tracking acknowledged offset against file size client-side rather
+// than via a server-pushed stream; uploadHandler's one-shot /upload
+// remains as a simpler alternative for callers that don't need to
+// resume a dropped connection.
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const resumablePath = "./resumable"
+
+// resumableMeta is the JSON sidecar persisted next to each in-progress
+// upload, so offset and checksum state survive a server restart.
+type resumableMeta struct {
+	ID        string `json:"id"`
+	Filename  string `json:"filename"`
+	User      string `json:"user"` // authenticated owner, "" when auth is disabled
+	Size      int64  `json:"size"`
+	Reserved  int64  `json:"reserved"` // bytes held against user's quota for this upload's lifetime, see createUploadHandler
+	Offset    int64  `json:"offset"`
+	Checksum  string `json:"checksum"`   // hex sha256 of bytes received so far
+	HashState string `json:"hash_state"` // base64 marshaled sha256 state, for resuming the hash
+}
+
+var resumableMu sync.Mutex // serializes PATCH appends per-process
+
+func init() {
+	if err := os.MkdirAll(resumablePath, os.ModePerm); err != nil {
+		panic("Could not create resumable upload directory: " + err.Error())
+	}
+}
+
+func (m *resumableMeta) partPath() string {
+	return filepath.Join(resumablePath, m.ID+".part")
+}
+
+func (m *resumableMeta) metaPath() string {
+	return filepath.Join(resumablePath, m.ID+".json")
+}
+
+func (m *resumableMeta) save() error {
+	f, err := os.Create(m.metaPath())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewEncoder(f).Encode(m)
+}
+
+func loadResumableMeta(id string) (*resumableMeta, error) {
+	f, err := os.Open(filepath.Join(resumablePath, id+".json"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var m resumableMeta
+	if err := json.NewDecoder(f).Decode(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// newUploadID returns a random hex identifier for a new resumable upload.
+func newUploadID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// createUploadRequest is the body of POST /uploads.
+type createUploadRequest struct {
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+}
+
+// createUploadHandler handles POST /uploads: it allocates an upload ID
+// and an empty part file, and returns both plus the expected size. When
+// an Authenticator is configured, the caller must authenticate the same
+// way as any other resumable-upload request; the resulting user owns
+// the upload and is the only one who may PATCH or finalize it.
+//
+// req.Size is checked against limits up front and, if it fits, reserved
+// against the user's quota for the whole lifetime of the upload rather
+// than just at finalize — otherwise a client could hold open arbitrarily
+// many never-finalized uploads and accumulate unbounded bytes under
+// resumablePath, since quotaUsed only sees files that have actually
+// landed in Storage. The reservation is released by
+// finalizeResumableUpload once the upload lands in Storage or is
+// rejected, or by deleteResumableUpload if the caller abandons it first.
+func createUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, err := authenticateRequest(r)
+	if err != nil {
+		http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var req createUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Could not parse request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Filename == "" || req.Size <= 0 {
+		http.Error(w, "filename and size are required", http.StatusBadRequest)
+		return
+	}
+
+	granted, release, err := limits.reserve(user, req.Size)
+	if err != nil {
+		http.Error(w, "Could not check quota: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if granted < req.Size {
+		release()
+		http.Error(w, "upload exceeds the allowed file size or quota", http.StatusBadRequest)
+		return
+	}
+
+	id, err := newUploadID()
+	if err != nil {
+		release()
+		http.Error(w, "Could not allocate upload id: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	m := &resumableMeta{
+		ID:       id,
+		Filename: filepath.Base(req.Filename),
+		User:     user,
+		Size:     req.Size,
+		Reserved: granted,
+	}
+
+	f, err := os.Create(m.partPath())
+	if err != nil {
+		release()
+		http.Error(w, "Could not create upload: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	f.Close()
+	if err := m.save(); err != nil {
+		release()
+		os.Remove(m.partPath())
+		http.Error(w, "Could not persist upload metadata: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m)
+}
+
+// resumableUploadHandler serves /uploads/{id}: HEAD returns the current
+// offset so a client knows where to resume, PATCH appends a byte range,
+// and DELETE abandons the upload, releasing the quota it reserved and
+// removing its part file and metadata — without it, an upload that's
+// created and never finished would hold its reservation until the
+// server restarts. All three require the same authentication as
+// creating the upload, and only the user that created it may act on it.
+func resumableUploadHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/uploads/")
+	if id == "" {
+		http.Error(w, "missing upload id", http.StatusBadRequest)
+		return
+	}
+
+	user, err := authenticateRequest(r)
+	if err != nil {
+		http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	m, err := loadResumableMeta(id)
+	if err != nil {
+		http.Error(w, "Unknown upload", http.StatusNotFound)
+		return
+	}
+	if m.User != user {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodHead:
+		w.Header().Set("Upload-Offset", strconv.FormatInt(m.Offset, 10))
+		w.Header().Set("Upload-Length", strconv.FormatInt(m.Size, 10))
+	case http.MethodPatch:
+		patchResumableUpload(w, r, m)
+	case http.MethodDelete:
+		deleteResumableUpload(w, m)
+	default:
+		http.Error(w, "HEAD, PATCH, or DELETE only", http.StatusMethodNotAllowed)
+	}
+}
+
+// deleteResumableUpload abandons an in-progress upload: it releases the
+// quota reserved for it and removes its part file and metadata sidecar,
+// the same cleanup finalizeResumableUpload does on a finished upload.
+//
+// m is loaded by resumableUploadHandler before resumableMu is taken, so a
+// concurrent request for the same id (a racing finalize, or a retried
+// DELETE) could already have removed the metadata by the time this runs.
+// Re-checking for it under the lock keeps this idempotent instead of
+// releasing the same reservation twice.
+func deleteResumableUpload(w http.ResponseWriter, m *resumableMeta) {
+	resumableMu.Lock()
+	defer resumableMu.Unlock()
+
+	if _, err := os.Stat(m.metaPath()); os.IsNotExist(err) {
+		http.Error(w, "Unknown upload", http.StatusNotFound)
+		return
+	}
+
+	releasePending(m.User, m.Reserved)
+	os.Remove(m.partPath())
+	os.Remove(m.metaPath())
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// patchResumableUpload appends the request body to the upload's part
+// file at the offset given by the Content-Range header, rejecting the
+// request if that offset doesn't match what the server has on disk so
+// a stale or out-of-order retry can't corrupt the file. The chunk is
+// bounded by the Content-Range's own end (which must fall within the
+// upload's declared Size, itself already reserved against the user's
+// quota by createUploadHandler) via a quotaReader, and the bytes
+// actually written must match what the header declared — this stops a
+// client from using Content-Range to smuggle more bytes into the part
+// file than either the header or the upload's reservation allow. Once
+// the full size has been received, the part file is atomically renamed
+// into uploadPath.
+func patchResumableUpload(w http.ResponseWriter, r *http.Request, m *resumableMeta) {
+	resumableMu.Lock()
+	defer resumableMu.Unlock()
+
+	start, end, err := parseContentRange(r.Header.Get("Content-Range"))
+	if err != nil {
+		http.Error(w, "Invalid Content-Range: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if start != m.Offset {
+		http.Error(w, fmt.Sprintf("offset mismatch: have %d, got %d", m.Offset, start), http.StatusConflict)
+		return
+	}
+	// Compare end against m.Size directly rather than via start+chunkLen:
+	// a crafted end near math.MaxInt64 would overflow that sum to a
+	// negative number and slip past the bound it's meant to enforce.
+	if end < start || end >= m.Size {
+		http.Error(w, "Content-Range exceeds the upload's declared size", http.StatusBadRequest)
+		return
+	}
+	chunkLen := end - start + 1
+
+	f, err := os.OpenFile(m.partPath(), os.O_WRONLY, 0644)
+	if err != nil {
+		http.Error(w, "Could not open upload: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Seek(m.Offset, io.SeekStart); err != nil {
+		http.Error(w, "Could not seek upload: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	hasher, err := m.resumeHasher()
+	if err != nil {
+		http.Error(w, "Could not resume checksum: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	body := newQuotaReader(r.Body, chunkLen)
+	n, err := io.Copy(f, io.TeeReader(body, hasher))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if isClientRejection(err) {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, "Could not write upload: "+err.Error(), status)
+		return
+	}
+	if n != chunkLen {
+		http.Error(w, fmt.Sprintf("wrote %d bytes, Content-Range declared %d", n, chunkLen), http.StatusBadRequest)
+		return
+	}
+
+	m.Offset += n
+	if err := m.saveHasher(hasher); err != nil {
+		http.Error(w, "Could not persist checksum: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := m.save(); err != nil {
+		http.Error(w, "Could not persist upload metadata: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if m.Offset >= m.Size {
+		if err := finalizeResumableUpload(m); err != nil {
+			status := http.StatusInternalServerError
+			if isClientRejection(err) {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, "Could not finalize upload: "+err.Error(), status)
+			return
+		}
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(m.Offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// resumeHasher rebuilds the sha256 state left off by a previous PATCH, so
+// the checksum covers the whole upload without rereading earlier bytes.
+func (m *resumableMeta) resumeHasher() (hash.Hash, error) {
+	h := sha256.New()
+	if m.HashState == "" {
+		return h, nil
+	}
+	state, err := base64.StdEncoding.DecodeString(m.HashState)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// saveHasher persists the hasher's state and its digest-so-far onto m.
+func (m *resumableMeta) saveHasher(h hash.Hash) error {
+	state, err := h.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return err
+	}
+	m.HashState = base64.StdEncoding.EncodeToString(state)
+	m.Checksum = hex.EncodeToString(h.Sum(nil))
+	return nil
+}
+
+// finalizeResumableUpload runs the completed part file through the same
+// inspector chain (MIME allowlist, size/quota limit, checksum, external
+// scanner) as a one-shot multipart upload, namespaced under m.User the
+// same way storeFilePart namespaces one. The local part file and
+// sidecar metadata are removed either way, so a file the inspector
+// chain rejects doesn't linger under resumablePath forever.
+//
+// It reuses createUploadHandler's reservation for m.Size directly
+// (via storeInspectedWithLimit) rather than releasing it and letting
+// storeInspected take a fresh one: releasing first would open a window
+// where a concurrent upload from the same user could be granted bytes
+// that were still needed here, letting the two together exceed quota.
+func finalizeResumableUpload(m *resumableMeta) error {
+	defer releasePending(m.User, m.Reserved)
+
+	f, err := os.Open(m.partPath())
+	if err != nil {
+		return err
+	}
+	_, storeErr := storeInspectedWithLimit(m.Filename, m.User, f, m.Reserved)
+	f.Close()
+
+	removeErr := os.Remove(m.partPath())
+	if removeErr == nil {
+		removeErr = os.Remove(m.metaPath())
+	}
+	if storeErr != nil {
+		return storeErr
+	}
+	return removeErr
+}
+
+// parseContentRange extracts the start and end byte offsets (inclusive)
+// from a header of the form "bytes start-end/total"; the total is
+// discarded since callers compare against the upload's own declared
+// Size instead.
+func parseContentRange(header string) (start int64, end int64, err error) {
+	var total int64
+	_, err = fmt.Sscanf(header, "bytes %d-%d/%d", &start, &end, &total)
+	return start, end, err
+}
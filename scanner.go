@@ -0,0 +1,117 @@
+// scanner.go
+// An optional external scanner hook that inspects a file after it has
+// been written to storage, so a virus scanner (or any other
+// gatekeeping tool) gets to see the whole file rather than a partial
+// stream. A rejected file is removed from storage rather than quarantined
+// in place, since backends like S3/GCS have no local "quarantine folder"
+// concept.
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Scanner inspects r (the full contents of a stored file) and returns an
+// error if it should be rejected.
+type Scanner interface {
+	Scan(r io.Reader) error
+}
+
+// newScannerFromEnv selects a Scanner based on ZERPA_SCANNER ("clamav",
+// "command", or unset to disable scanning).
+func newScannerFromEnv() (Scanner, error) {
+	switch kind := os.Getenv("ZERPA_SCANNER"); kind {
+	case "":
+		return nil, nil
+	case "clamav":
+		addr := os.Getenv("ZERPA_SCANNER_CLAMAV_ADDR")
+		if addr == "" {
+			return nil, fmt.Errorf("ZERPA_SCANNER_CLAMAV_ADDR is required for the clamav scanner")
+		}
+		return &clamavScanner{addr: addr}, nil
+	case "command":
+		cmd := os.Getenv("ZERPA_SCANNER_COMMAND")
+		if cmd == "" {
+			return nil, fmt.Errorf("ZERPA_SCANNER_COMMAND is required for the command scanner")
+		}
+		return &commandScanner{command: cmd}, nil
+	default:
+		return nil, fmt.Errorf("unknown ZERPA_SCANNER %q", kind)
+	}
+}
+
+// clamavScanner talks to clamd's INSTREAM protocol over TCP: the file is
+// sent as a series of 4-byte-length-prefixed chunks terminated by a
+// zero-length chunk, and clamd replies with a line containing "FOUND" if
+// it detected anything.
+type clamavScanner struct {
+	addr string
+}
+
+func (s *clamavScanner) Scan(r io.Reader) error {
+	conn, err := net.Dial("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("could not reach clamd at %s: %w", s.addr, err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			var size [4]byte
+			binary.BigEndian.PutUint32(size[:], uint32(n))
+			if _, werr := conn.Write(size[:]); werr != nil {
+				return werr
+			}
+			if _, werr := conn.Write(buf[:n]); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if strings.Contains(reply, "FOUND") {
+		return fmt.Errorf("clamav rejected file: %s", reply)
+	}
+	return nil
+}
+
+// commandScanner runs a configured shell command with the file on
+// stdin; a non-zero exit status rejects the file.
+type commandScanner struct {
+	command string
+}
+
+func (s *commandScanner) Scan(r io.Reader) error {
+	cmd := exec.Command("sh", "-c", s.command)
+	cmd.Stdin = r
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("scanner command rejected file: %w: %s", err, output)
+	}
+	return nil
+}
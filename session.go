@@ -0,0 +1,117 @@
+// session.go
+// A minimal cookie-based session and CSRF layer for the browser upload
+// form, separate from the API-style token/htpasswd/HMAC credentials
+// uploadHandler reads from the multipart stream itself.
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"sync"
+)
+
+const sessionCookieName = "zerpa_session"
+
+type session struct {
+	user string
+	csrf string
+}
+
+var (
+	sessionMu sync.Mutex
+	sessions  = map[string]*session{}
+)
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// newSession creates a session for user and returns its cookie value.
+func newSession(user string) (*session, string, error) {
+	id, err := randomHex(16)
+	if err != nil {
+		return nil, "", err
+	}
+	csrf, err := randomHex(16)
+	if err != nil {
+		return nil, "", err
+	}
+	s := &session{user: user, csrf: csrf}
+
+	sessionMu.Lock()
+	sessions[id] = s
+	sessionMu.Unlock()
+
+	return s, id, nil
+}
+
+func sessionFromRequest(r *http.Request) *session {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return nil
+	}
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+	return sessions[cookie.Value]
+}
+
+// loginHandler authenticates a browser form POST (username/password or
+// token, depending on the configured Authenticator) and starts a
+// session, so the upload form can submit a CSRF token instead of raw
+// credentials on every request.
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	if authenticator == nil {
+		http.Error(w, "authentication is not configured", http.StatusNotImplemented)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Could not parse form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	creds := Credentials{
+		Token:    r.FormValue("token"),
+		Username: r.FormValue("username"),
+		Password: r.FormValue("password"),
+	}
+
+	user, err := authenticator.Authenticate(creds)
+	if err != nil {
+		http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	_, sessionID, err := newSession(user)
+	if err != nil {
+		http.Error(w, "Could not create session: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    sessionID,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// validCSRF reports whether token matches the CSRF token issued to s.
+func validCSRF(s *session, token string) bool {
+	if s == nil || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(s.csrf), []byte(token)) == 1
+}
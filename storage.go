@@ -0,0 +1,61 @@
+// storage.go
+// Storage abstracts where uploaded files actually live, so the HTTP
+// handlers don't need to know whether a file ended up on local disk, in
+// S3, or in GCS. The backend is selected once at startup via
+// newStorageFromEnv and shared by every handler.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// FileInfo describes one stored file as the listing page needs it: a
+// display name, its size, and a URL to fetch it. URL always points back
+// through this server's own /uploaded/ route (see servedURL) rather
+// than a backend-specific link, so servedFileHandler's per-user access
+// check applies the same way regardless of which Storage is active.
+type FileInfo struct {
+	Name string
+	Size int64
+	URL  string
+}
+
+// Storage is implemented by every upload backend. Put is expected to
+// stream r directly into the backend without first staging the whole
+// file locally. Names may contain "/" to namespace files (e.g. per
+// user); List takes a prefix so callers can scope a listing to their own
+// namespace instead of seeing every stored file.
+type Storage interface {
+	Put(name string, r io.Reader) (url string, err error)
+	List(prefix string) ([]FileInfo, error)
+	Open(name string) (io.ReadCloser, error)
+	Delete(name string) error
+}
+
+// servedURL returns the path FileInfo.URL should use for a stored
+// object on a remote backend (S3, GCS): a link back through this
+// server's own /uploaded/ route rather than a direct bucket URL, so the
+// same per-user access check servedFileHandler applies to local files
+// also applies to objects living in a remote bucket.
+func servedURL(name string) string {
+	return "/uploaded/" + name
+}
+
+// newStorageFromEnv selects a Storage implementation based on the
+// ZERPA_STORAGE_BACKEND env var ("local", "s3", or "gcs"), defaulting to
+// local disk when unset.
+func newStorageFromEnv() (Storage, error) {
+	switch backend := os.Getenv("ZERPA_STORAGE_BACKEND"); backend {
+	case "", "local":
+		return newLocalStorage(uploadPath)
+	case "s3":
+		return newS3StorageFromEnv()
+	case "gcs":
+		return newGCSStorageFromEnv()
+	default:
+		return nil, fmt.Errorf("unknown ZERPA_STORAGE_BACKEND %q", backend)
+	}
+}
@@ -0,0 +1,146 @@
+// storage_gcs.go
+// A Google Cloud Storage Storage backend using the JSON API directly
+// over net/http (no GCS client library dependency). Put streams the
+// object body straight to the upload endpoint via chunked
+// transfer-encoding, so no local staging copy is needed.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+const (
+	gcsAPIBase    = "https://storage.googleapis.com/storage/v1/b"
+	gcsUploadBase = "https://storage.googleapis.com/upload/storage/v1/b"
+)
+
+type gcsStorage struct {
+	bucket string
+	token  string // OAuth2 access token; refreshing it is the operator's responsibility
+	client *http.Client
+}
+
+// newGCSStorageFromEnv builds a gcsStorage from ZERPA_GCS_* env vars.
+// ZERPA_GCS_TOKEN is a short-lived OAuth2 access token for a service
+// account with storage.objects admin on the bucket.
+func newGCSStorageFromEnv() (*gcsStorage, error) {
+	bucket := os.Getenv("ZERPA_GCS_BUCKET")
+	token := os.Getenv("ZERPA_GCS_TOKEN")
+	if bucket == "" || token == "" {
+		return nil, fmt.Errorf("ZERPA_GCS_BUCKET and ZERPA_GCS_TOKEN are required for the gcs backend")
+	}
+	return &gcsStorage{bucket: bucket, token: token, client: http.DefaultClient}, nil
+}
+
+func (s *gcsStorage) authHeader(req *http.Request) {
+	req.Header.Set("Authorization", "Bearer "+s.token)
+}
+
+func (s *gcsStorage) Put(name string, r io.Reader) (string, error) {
+	u := fmt.Sprintf("%s/%s/o?uploadType=media&name=%s", gcsUploadBase, s.bucket, url.QueryEscape(name))
+	req, err := http.NewRequest(http.MethodPost, u, io.NopCloser(r))
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = -1 // stream via chunked transfer-encoding, no staging pass
+	s.authHeader(req)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("gcs put %s: unexpected status %s", name, resp.Status)
+	}
+	return servedURL(name), nil
+}
+
+func (s *gcsStorage) mediaURL(name string) string {
+	return fmt.Sprintf("%s/%s/o/%s?alt=media", gcsAPIBase, s.bucket, url.PathEscape(name))
+}
+
+func (s *gcsStorage) Open(name string) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, s.mediaURL(name), nil)
+	if err != nil {
+		return nil, err
+	}
+	s.authHeader(req)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("gcs get %s: unexpected status %s", name, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+func (s *gcsStorage) Delete(name string) error {
+	u := fmt.Sprintf("%s/%s/o/%s", gcsAPIBase, s.bucket, url.PathEscape(name))
+	req, err := http.NewRequest(http.MethodDelete, u, nil)
+	if err != nil {
+		return err
+	}
+	s.authHeader(req)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("gcs delete %s: unexpected status %s", name, resp.Status)
+	}
+	return nil
+}
+
+// gcsListResponse is the subset of the objects.list response we need.
+type gcsListResponse struct {
+	Items []struct {
+		Name string `json:"name"`
+		Size string `json:"size"` // GCS returns size as a decimal string
+	} `json:"items"`
+}
+
+func (s *gcsStorage) List(prefix string) ([]FileInfo, error) {
+	u := fmt.Sprintf("%s/%s/o", gcsAPIBase, s.bucket)
+	if prefix != "" {
+		u += "?prefix=" + url.QueryEscape(prefix)
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.authHeader(req)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("gcs list %s: unexpected status %s", s.bucket, resp.Status)
+	}
+
+	var parsed gcsListResponse
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return nil, err
+	}
+
+	files := make([]FileInfo, 0, len(parsed.Items))
+	for _, item := range parsed.Items {
+		var size int64
+		fmt.Sscanf(item.Size, "%d", &size)
+		files = append(files, FileInfo{Name: item.Name, Size: size, URL: servedURL(item.Name)})
+	}
+	return files, nil
+}
@@ -0,0 +1,110 @@
+// storage_local.go
+// The default Storage backend: files live under a directory on local
+// disk, same as zerpa's original behavior. Names may contain "/" to
+// namespace files into subdirectories (e.g. per user).
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type localStorage struct {
+	dir string
+}
+
+func newLocalStorage(dir string) (*localStorage, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return &localStorage{dir: dir}, nil
+}
+
+// resolve turns a caller-supplied name into a path under s.dir, cleaning
+// it and rejecting any attempt to escape the storage root.
+func (s *localStorage) resolve(name string) (string, error) {
+	cleaned := filepath.Clean("/" + name)[1:]
+	if cleaned == "" || cleaned == "." || strings.HasPrefix(cleaned, "..") {
+		return "", fmt.Errorf("invalid storage name %q", name)
+	}
+	return filepath.Join(s.dir, cleaned), nil
+}
+
+func (s *localStorage) Put(name string, r io.Reader) (string, error) {
+	dstPath, err := s.resolve(name)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(dstPath), os.ModePerm); err != nil {
+		return "", err
+	}
+
+	dst, err := os.Create(dstPath)
+	if err != nil {
+		return "", err
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, r); err != nil {
+		return "", err
+	}
+	return "/uploaded/" + filepath.ToSlash(name), nil
+}
+
+func (s *localStorage) List(prefix string) ([]FileInfo, error) {
+	root, err := s.resolve(prefix)
+	if err != nil && prefix != "" {
+		return nil, err
+	}
+	if prefix == "" {
+		root = s.dir
+	}
+
+	files := []FileInfo{}
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		files = append(files, FileInfo{
+			Name: rel,
+			Size: info.Size(),
+			URL:  "/uploaded/" + rel,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func (s *localStorage) Open(name string) (io.ReadCloser, error) {
+	path, err := s.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (s *localStorage) Delete(name string) error {
+	path, err := s.resolve(name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
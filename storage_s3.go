@@ -0,0 +1,215 @@
+// storage_s3.go
+// An S3-compatible Storage backend, signed with AWS Signature Version 4
+// using only the standard library (no AWS SDK dependency). Put streams
+// the object body straight from the multipart part to the PUT request
+// using chunked transfer-encoding and the "UNSIGNED-PAYLOAD" content
+// hash, so no local staging copy is needed.
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+type s3Storage struct {
+	bucket    string
+	region    string
+	endpoint  string // e.g. https://s3.us-east-1.amazonaws.com
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+// newS3StorageFromEnv builds an s3Storage from ZERPA_S3_* env vars.
+// ZERPA_S3_ENDPOINT is optional and defaults to the standard AWS
+// regional endpoint, so S3-compatible stores (e.g. MinIO) can be used by
+// overriding it.
+func newS3StorageFromEnv() (*s3Storage, error) {
+	bucket := os.Getenv("ZERPA_S3_BUCKET")
+	region := os.Getenv("ZERPA_S3_REGION")
+	if bucket == "" || region == "" {
+		return nil, fmt.Errorf("ZERPA_S3_BUCKET and ZERPA_S3_REGION are required for the s3 backend")
+	}
+	endpoint := os.Getenv("ZERPA_S3_ENDPOINT")
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+	return &s3Storage{
+		bucket:    bucket,
+		region:    region,
+		endpoint:  strings.TrimRight(endpoint, "/"),
+		accessKey: os.Getenv("ZERPA_S3_ACCESS_KEY"),
+		secretKey: os.Getenv("ZERPA_S3_SECRET_KEY"),
+		client:    http.DefaultClient,
+	}, nil
+}
+
+func (s *s3Storage) objectURL(name string) string {
+	return fmt.Sprintf("%s/%s/%s", s.endpoint, s.bucket, url.PathEscape(name))
+}
+
+func (s *s3Storage) Put(name string, r io.Reader) (string, error) {
+	req, err := http.NewRequest(http.MethodPut, s.objectURL(name), io.NopCloser(r))
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = -1 // stream via chunked transfer-encoding, no staging pass
+	s.sign(req, unsignedPayload)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("s3 put %s: unexpected status %s", name, resp.Status)
+	}
+	return servedURL(name), nil
+}
+
+func (s *s3Storage) Open(name string) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, s.objectURL(name), nil)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, emptyPayloadHash)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 get %s: unexpected status %s", name, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+func (s *s3Storage) Delete(name string) error {
+	req, err := http.NewRequest(http.MethodDelete, s.objectURL(name), nil)
+	if err != nil {
+		return err
+	}
+	s.sign(req, emptyPayloadHash)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("s3 delete %s: unexpected status %s", name, resp.Status)
+	}
+	return nil
+}
+
+// listBucketResult is the subset of a ListObjectsV2 response we need.
+type listBucketResult struct {
+	XMLName  xml.Name `xml:"ListBucketResult"`
+	Contents []struct {
+		Key  string `xml:"Key"`
+		Size int64  `xml:"Size"`
+	} `xml:"Contents"`
+}
+
+func (s *s3Storage) List(prefix string) ([]FileInfo, error) {
+	query := "list-type=2"
+	if prefix != "" {
+		query += "&prefix=" + url.QueryEscape(prefix)
+	}
+	req, err := http.NewRequest(http.MethodGet, s.endpoint+"/"+s.bucket+"?"+query, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, emptyPayloadHash)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("s3 list %s: unexpected status %s", s.bucket, resp.Status)
+	}
+
+	var result listBucketResult
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	files := make([]FileInfo, 0, len(result.Contents))
+	for _, obj := range result.Contents {
+		files = append(files, FileInfo{Name: obj.Key, Size: obj.Size, URL: servedURL(obj.Key)})
+	}
+	return files, nil
+}
+
+var emptyPayloadHash = hex.EncodeToString(sha256.New().Sum(nil))
+
+// sign adds AWS Signature Version 4 headers to req in place.
+func (s *s3Storage) sign(req *http.Request, payloadHash string) {
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	dateStamp := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	req.Host = req.URL.Host
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := fmt.Sprintf("host:%s\nx-amz-content-sha256:%s\nx-amz-date:%s\n",
+		req.Host, payloadHash, amzDate)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", dateStamp, s.region)
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hashHex(canonicalRequest),
+	}, "\n")
+
+	signingKey := s3SigningKey(s.secretKey, dateStamp, s.region)
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+func s3SigningKey(secretKey, dateStamp, region string) []byte {
+	kDate := hmacSHA256([]byte("AWS4"+secretKey), dateStamp)
+	kRegion := hmacSHA256(kDate, region)
+	kService := hmacSHA256(kRegion, "s3")
+	return hmacSHA256(kService, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hashHex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}